@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package certloader
+
+import (
+	"crypto/sha256"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/cilium/cilium/pkg/fswatcher"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/time"
+)
+
+// defaultPollInterval is how often a pollWatcher stats the tracked files
+// when options.PollInterval is left unset.
+const defaultPollInterval = time.Minute
+
+// DefaultReconcileInterval is the ReconcileInterval NewWatcher configures.
+// Callers going through NewWatcherWithOptions get no periodic
+// reconciliation unless they set Options.ReconcileInterval themselves,
+// since the zero value there means "disabled".
+const DefaultReconcileInterval = 5 * time.Minute
+
+// Options configures the file-watching backend used by NewWatcherWithOptions.
+type Options struct {
+	// Poll, when true, always uses the polling backend instead of fsnotify.
+	// This is useful on filesystems where fsnotify is known to silently
+	// drop events, such as some overlay/fuse mounts and NFS-backed secret
+	// stores.
+	Poll bool
+	// PollInterval is how often the polling backend stats the tracked
+	// files. Defaults to one minute.
+	PollInterval time.Duration
+	// Metrics, if non-nil, is updated on every reload attempt. Register it
+	// with the daemon's prometheus registry to expose it.
+	Metrics *Metrics
+	// ReconcileInterval is how often the Watcher re-hashes the tracked
+	// files as a safety net against missed fsnotify events (queue overflow,
+	// bind-mount boundaries, a watch replaced while briefly stopped).
+	// NewWatcher and FutureWatcher set it to DefaultReconcileInterval (5
+	// minutes); here the zero value disables periodic reconciliation.
+	ReconcileInterval time.Duration
+}
+
+// pollWatcher is a watcherBackend that stats the tracked CA/cert/key files
+// at a fixed interval and synthesizes an event whenever a file's mtime,
+// size, or content hash changes since the last poll. It's a fallback for
+// filesystems and container runtimes where fsnotify is unavailable or
+// unreliable, modeled on Docker's pkg/filenotify poller.
+type pollWatcher struct {
+	log    *slog.Logger
+	events chan fswatcher.Event
+	errors chan error
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func newPollWatcher(logger *slog.Logger, caFiles []string, certFile, privkeyFile string, options Options) (*pollWatcher, error) {
+	interval := options.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	paths := make([]string, 0, len(caFiles)+2)
+	if certFile != "" {
+		paths = append(paths, certFile)
+	}
+	if privkeyFile != "" {
+		paths = append(paths, privkeyFile)
+	}
+	for _, path := range caFiles {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	w := &pollWatcher{
+		log:    logger,
+		events: make(chan fswatcher.Event),
+		errors: make(chan error),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	w.log.Info("Polling for TLS file changes", logfields.Interval, interval)
+	go w.run(paths, interval)
+	return w, nil
+}
+
+func (w *pollWatcher) EventsCh() <-chan fswatcher.Event { return w.events }
+func (w *pollWatcher) ErrorsCh() <-chan error           { return w.errors }
+
+func (w *pollWatcher) Close() error {
+	select {
+	case <-w.stop:
+	default:
+		close(w.stop)
+	}
+	<-w.done
+	return nil
+}
+
+func (w *pollWatcher) run(paths []string, interval time.Duration) {
+	defer close(w.done)
+
+	last := make(map[string]fileFingerprint, len(paths))
+	for _, path := range paths {
+		// Best-effort initial snapshot; a missing file here just means the
+		// first real change will be reported once it appears.
+		fp, err := statFingerprint(path)
+		if err == nil {
+			last[path] = fp
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, path := range paths {
+				fp, err := statFingerprint(path)
+				if err != nil {
+					if !os.IsNotExist(err) {
+						select {
+						case w.errors <- err:
+						case <-w.stop:
+							return
+						}
+					}
+					continue
+				}
+				if prev, ok := last[path]; ok && prev == fp {
+					continue
+				}
+				last[path] = fp
+				select {
+				case w.events <- fswatcher.Event{Name: path, Op: fswatcher.Write}:
+				case <-w.stop:
+					return
+				}
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// fileFingerprint is a cheap change marker for a polled file: its size,
+// mtime, and content hash. Comparing all three avoids missing a change that
+// lands within the mtime granularity of the underlying filesystem.
+type fileFingerprint struct {
+	size    int64
+	modTime int64
+	sha256  [sha256.Size]byte
+}
+
+func statFingerprint(path string) (fileFingerprint, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileFingerprint{}, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fileFingerprint{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fileFingerprint{}, err
+	}
+
+	fp := fileFingerprint{
+		size:    info.Size(),
+		modTime: info.ModTime().UnixNano(),
+	}
+	copy(fp.sha256[:], h.Sum(nil))
+	return fp, nil
+}
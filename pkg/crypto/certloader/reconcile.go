@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package certloader
+
+// fileHashCache tracks the fileFingerprint of a set of files as observed at
+// the last check, so periodic reconciliation can detect content changes that
+// neither fsnotify nor the polling backend reported. It reuses the same
+// size+mtime+sha256 fingerprint the polling backend computes, rather than a
+// second, inconsistent notion of "changed".
+type fileHashCache struct {
+	fingerprints map[string]fileFingerprint
+}
+
+func newFileHashCache(paths []string) *fileHashCache {
+	c := &fileHashCache{fingerprints: make(map[string]fileFingerprint, len(paths))}
+	c.changed(paths)
+	return c
+}
+
+// changed re-fingerprints each of paths, updates the cache, and reports
+// whether any of them differed from the previously cached value. A file
+// that is transiently unreadable (e.g. mid atomic swap) is left untouched
+// rather than treated as a change.
+func (c *fileHashCache) changed(paths []string) bool {
+	changed := false
+	for _, path := range paths {
+		fp, err := statFingerprint(path)
+		if err != nil {
+			continue
+		}
+		if prev, ok := c.fingerprints[path]; ok && prev == fp {
+			continue
+		}
+		c.fingerprints[path] = fp
+		changed = true
+	}
+	return changed
+}
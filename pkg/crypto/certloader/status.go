@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package certloader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"sync"
+
+	"github.com/cilium/cilium/pkg/time"
+)
+
+var errNoLeafCertificate = errors.New("keypair has no certificate")
+
+// KeypairStatus describes the currently loaded TLS keypair.
+type KeypairStatus struct {
+	// SerialNumber is the leaf certificate's serial number, or nil if no
+	// keypair has been successfully loaded yet.
+	SerialNumber *big.Int
+	// DNSNames and IPAddresses are the leaf certificate's SANs.
+	DNSNames    []string
+	IPAddresses []string
+	// NotAfter is the leaf certificate's expiry.
+	NotAfter time.Time
+	// LastReload is when this keypair was loaded.
+	LastReload time.Time
+	// LastError is the error from the most recent reload attempt, which may
+	// postdate LastReload if that attempt failed and the prior keypair is
+	// still in use.
+	LastError error
+}
+
+// CAStatus describes one of the currently loaded certificate authorities.
+type CAStatus struct {
+	Subject pkix.Name
+}
+
+// WatcherStatus is a point-in-time snapshot of a Watcher, returned by
+// Watcher.Status for introspection by `cilium status` and the operator.
+type WatcherStatus struct {
+	Keypair KeypairStatus
+	CAs     []CAStatus
+	// CALastReload is when the CA pool was last successfully reloaded.
+	CALastReload time.Time
+	// CALastError is the error from the most recent CA reload attempt.
+	CALastError error
+}
+
+// watcherStatus holds the mutable state backing Watcher.Status, updated as
+// reloads happen and read back out under mu.
+type watcherStatus struct {
+	mu sync.Mutex
+	st WatcherStatus
+}
+
+func (s *watcherStatus) recordKeypair(cert *x509.Certificate, reloadTime time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.st.Keypair.LastError = err
+		return
+	}
+	s.st.Keypair = KeypairStatus{
+		SerialNumber: cert.SerialNumber,
+		DNSNames:     cert.DNSNames,
+		IPAddresses:  ipStrings(cert.IPAddresses),
+		NotAfter:     cert.NotAfter,
+		LastReload:   reloadTime,
+	}
+}
+
+func (s *watcherStatus) recordCA(certs []*x509.Certificate, reloadTime time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.st.CALastError = err
+		return
+	}
+	cas := make([]CAStatus, 0, len(certs))
+	for _, c := range certs {
+		cas = append(cas, CAStatus{Subject: c.Subject})
+	}
+	s.st.CAs = cas
+	s.st.CALastReload = reloadTime
+	s.st.CALastError = nil
+}
+
+func (s *watcherStatus) snapshot() WatcherStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.st
+}
+
+func ipStrings(ips []net.IP) []string {
+	out := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		out = append(out, ip.String())
+	}
+	return out
+}
+
+// leafCertificate returns the parsed leaf certificate of kp, parsing it from
+// kp.Certificate[0] if tls.X509KeyPair/LoadX509KeyPair didn't already
+// populate kp.Leaf.
+func leafCertificate(kp *tls.Certificate) (*x509.Certificate, error) {
+	if kp.Leaf != nil {
+		return kp.Leaf, nil
+	}
+	if len(kp.Certificate) == 0 {
+		return nil, errNoLeafCertificate
+	}
+	return x509.ParseCertificate(kp.Certificate[0])
+}
+
+// parseCACerts parses each PEM-encoded CA bundle in raw into its individual
+// certificates, for use in WatcherStatus. Unparseable entries are skipped.
+func parseCACerts(raw [][]byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for _, bundle := range raw {
+		rest := bundle
+		for len(rest) > 0 {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				continue
+			}
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
+
+// Status returns a point-in-time snapshot of the Watcher's currently loaded
+// keypair and CA state, including the last reload time and error per
+// bucket.
+func (w *Watcher) Status() WatcherStatus {
+	return w.status.snapshot()
+}
@@ -0,0 +1,211 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package certloader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/time"
+)
+
+// KeypairEvent is sent on a Watcher's KeypairUpdates channel whenever the
+// keypair is successfully reloaded.
+type KeypairEvent struct {
+	Certificate *tls.Certificate
+}
+
+// CAEvent is sent on a Watcher's CAUpdates channel whenever the certificate
+// authority is successfully reloaded.
+type CAEvent struct {
+	CertPool *x509.CertPool
+	RawCerts [][]byte
+}
+
+// subscriptions holds the callback and channel subscribers of a Watcher.
+// Use newSubscriptions to construct one; the zero value's channels are nil.
+type subscriptions struct {
+	mu              sync.Mutex
+	keypairCallback []func(*tls.Certificate)
+	caCallback      []func(*x509.CertPool, [][]byte)
+	keypairUpdates  chan KeypairEvent
+	caUpdates       chan CAEvent
+}
+
+func newSubscriptions() subscriptions {
+	return subscriptions{
+		keypairUpdates: make(chan KeypairEvent, 1),
+		caUpdates:      make(chan CAEvent, 1),
+	}
+}
+
+// OnKeypairReload registers a callback invoked, in order of registration,
+// every time a new keypair is successfully reloaded off a fswatcher or
+// reconcile event. It does NOT fire for the keypair already loaded by the
+// time the Watcher was constructed or became ready: that initial load
+// happens before any callback can be registered. Callers that need the
+// current keypair right away should read it via GetCertificate/
+// GetClientCertificate, or call Reload() immediately after registering to
+// have it delivered through this same callback. Callbacks run on the
+// goroutine performing the reload (the Watcher's internal goroutine, or the
+// caller of Reload), so they must not block or call back into the Watcher.
+func (w *Watcher) OnKeypairReload(cb func(*tls.Certificate)) {
+	w.subs.mu.Lock()
+	defer w.subs.mu.Unlock()
+	w.subs.keypairCallback = append(w.subs.keypairCallback, cb)
+}
+
+// OnCAReload registers a callback invoked, in order of registration, every
+// time the certificate authority pool is successfully reloaded off a
+// fswatcher or reconcile event. It does NOT fire for the CA pool already
+// loaded by the time the Watcher was constructed or became ready: that
+// initial load happens before any callback can be registered. Callers that
+// need the current CA pool right away should call Reload() immediately
+// after registering to have it delivered through this same callback.
+// Callbacks run on the goroutine performing the reload (the Watcher's
+// internal goroutine, or the caller of Reload), so they must not block or
+// call back into the Watcher.
+func (w *Watcher) OnCAReload(cb func(*x509.CertPool, [][]byte)) {
+	w.subs.mu.Lock()
+	defer w.subs.mu.Unlock()
+	w.subs.caCallback = append(w.subs.caCallback, cb)
+}
+
+// KeypairUpdates returns a channel that receives a KeypairEvent every time
+// the keypair is successfully reloaded. Delivery is non-blocking: a slow
+// consumer has its pending event overwritten by the newer one instead of
+// stalling the Watcher.
+func (w *Watcher) KeypairUpdates() <-chan KeypairEvent {
+	return w.subs.keypairUpdates
+}
+
+// CAUpdates returns a channel that receives a CAEvent every time the
+// certificate authority is successfully reloaded. Delivery is non-blocking:
+// a slow consumer has its pending event overwritten by the newer one
+// instead of stalling the Watcher.
+func (w *Watcher) CAUpdates() <-chan CAEvent {
+	return w.subs.caUpdates
+}
+
+// Reload forces a synchronous reload of both the keypair and the CA,
+// notifying subscribers of each bucket that reloaded successfully, whether
+// or not its content changed. It returns
+// the first error encountered, having still attempted both reloads. Callers
+// of OnKeypairReload/OnCAReload that need the current value right after
+// registering, rather than waiting for the next fswatcher event, can call
+// Reload to have it delivered through the callback they just registered.
+func (w *Watcher) Reload() error {
+	_, keypairErr := w.reloadKeypair()
+	_, caErr := w.reloadCA()
+	if keypairErr != nil {
+		return keypairErr
+	}
+	return caErr
+}
+
+// reloadKeypair reloads the keypair, updating Status() and Metrics, and on
+// success notifies subscribers.
+func (w *Watcher) reloadKeypair() (*tls.Certificate, error) {
+	keypair, err := w.ReloadKeypair()
+	if err != nil {
+		w.status.recordKeypair(nil, time.Time{}, err)
+		w.metrics.observeReloadError(labelKindKeypair)
+		return nil, err
+	}
+	now := time.Now()
+	leaf, leafErr := leafCertificate(keypair)
+	if leafErr == nil {
+		w.status.recordKeypair(leaf, now, nil)
+		w.metrics.observeKeypairReload(float64(leaf.NotAfter.Unix()), float64(now.Unix()))
+	}
+	w.publishKeypair(keypair)
+	return keypair, nil
+}
+
+// reloadCA reloads the certificate authority, updating Status() and
+// Metrics, and on success notifies subscribers.
+func (w *Watcher) reloadCA() (*x509.CertPool, error) {
+	pool, err := w.ReloadCA()
+	if err != nil {
+		w.status.recordCA(nil, time.Time{}, err)
+		w.metrics.observeReloadError(labelKindCA)
+		return nil, err
+	}
+	raw := w.rawCACerts()
+	now := time.Now()
+	w.status.recordCA(parseCACerts(raw), now, nil)
+	w.metrics.observeCAReload(float64(now.Unix()))
+	w.publishCA(pool, raw)
+	return pool, nil
+}
+
+// publishKeypair invokes the registered keypair callbacks and delivers a
+// KeypairEvent to KeypairUpdates, dropping any undelivered previous event.
+func (w *Watcher) publishKeypair(keypair *tls.Certificate) {
+	w.subs.mu.Lock()
+	callbacks := w.subs.keypairCallback
+	w.subs.mu.Unlock()
+	for _, cb := range callbacks {
+		cb(keypair)
+	}
+
+	select {
+	case w.subs.keypairUpdates <- KeypairEvent{Certificate: keypair}:
+	default:
+		select {
+		case <-w.subs.keypairUpdates:
+		default:
+		}
+		select {
+		case w.subs.keypairUpdates <- KeypairEvent{Certificate: keypair}:
+		default:
+		}
+	}
+}
+
+// publishCA invokes the registered CA callbacks and delivers a CAEvent to
+// CAUpdates, dropping any undelivered previous event.
+func (w *Watcher) publishCA(pool *x509.CertPool, raw [][]byte) {
+	w.subs.mu.Lock()
+	callbacks := w.subs.caCallback
+	w.subs.mu.Unlock()
+	for _, cb := range callbacks {
+		cb(pool, raw)
+	}
+
+	event := CAEvent{CertPool: pool, RawCerts: raw}
+	select {
+	case w.subs.caUpdates <- event:
+	default:
+		select {
+		case <-w.subs.caUpdates:
+		default:
+		}
+		select {
+		case w.subs.caUpdates <- event:
+		default:
+		}
+	}
+}
+
+// rawCACerts best-effort reads the raw contents of the tracked CA files, for
+// subscribers (e.g. SPIFFE trust bundle builders) that need the DER/PEM
+// bytes rather than just the parsed pool. Unreadable files are skipped; a
+// subsequent successful reload through the normal watch loop implies the
+// files were readable when ReloadCA() itself succeeded.
+func (w *Watcher) rawCACerts() [][]byte {
+	raw := make([][]byte, 0, len(w.FileReloader.caFiles))
+	for _, path := range w.FileReloader.caFiles {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			w.log.Debug("Failed to read CA file for subscribers", logfields.Path, path, logfields.Error, err)
+			continue
+		}
+		raw = append(raw, b)
+	}
+	return raw
+}
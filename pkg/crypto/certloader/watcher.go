@@ -4,7 +4,10 @@
 package certloader
 
 import (
+	"errors"
 	"log/slog"
+	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/cilium/cilium/pkg/fswatcher"
@@ -14,20 +17,41 @@ import (
 
 const watcherEventCoalesceWindow = 100 * time.Millisecond
 
+// maxTransientAbsenceRetries caps how many consecutive
+// watcherEventCoalesceWindow retries a reload attempts against
+// isTransientAbsence before falling back to the normal Warn path. At 100ms
+// per retry this gives a symlink swap 2 seconds to settle, which is far
+// longer than the few-millisecond window kubelet actually needs, while
+// still bounding a permanently missing file to a single Warn instead of an
+// indefinite ~10Hz busy-loop.
+const maxTransientAbsenceRetries = 20
+
 // Watcher is a set of TLS configuration files including CA files, and a
 // certificate along with its private key. The files are watched for change and
 // reloaded automatically.
 type Watcher struct {
 	*FileReloader
-	log       *slog.Logger
-	fswatcher *fswatcher.Watcher
-	stop      chan struct{}
+	log               *slog.Logger
+	fswatcher         watcherBackend
+	stop              chan struct{}
+	subs              subscriptions
+	status            watcherStatus
+	metrics           *Metrics
+	reconcileInterval time.Duration
 }
 
 // NewWatcher returns a Watcher that watch over the given file
 // paths. The given files are expected to already exists when this function is
 // called. On success, the returned Watcher is ready to use.
 func NewWatcher(log *slog.Logger, caFiles []string, certFile, privkeyFile string) (*Watcher, error) {
+	return NewWatcherWithOptions(log, caFiles, certFile, privkeyFile, Options{
+		ReconcileInterval: DefaultReconcileInterval,
+	})
+}
+
+// NewWatcherWithOptions is like NewWatcher, but lets the caller select the
+// underlying file-watching backend via options. See Options for details.
+func NewWatcherWithOptions(log *slog.Logger, caFiles []string, certFile, privkeyFile string, options Options) (*Watcher, error) {
 	r, err := NewFileReloaderReady(caFiles, certFile, privkeyFile)
 	if err != nil {
 		return nil, err
@@ -35,15 +59,18 @@ func NewWatcher(log *slog.Logger, caFiles []string, certFile, privkeyFile string
 	// An error here would be unexpected as we were able to create a
 	// FileReloader having read the files, so the files should exist and be
 	// "watchable".
-	fswatcher, err := newFsWatcher(log, caFiles, certFile, privkeyFile)
+	backend, err := newWatcherBackend(log, caFiles, certFile, privkeyFile, options)
 	if err != nil {
 		return nil, err
 	}
 	w := &Watcher{
-		FileReloader: r,
-		log:          log,
-		fswatcher:    fswatcher,
-		stop:         make(chan struct{}),
+		FileReloader:      r,
+		log:               log,
+		fswatcher:         backend,
+		stop:              make(chan struct{}),
+		subs:              newSubscriptions(),
+		metrics:           options.Metrics,
+		reconcileInterval: options.ReconcileInterval,
 	}
 
 	w.Watch()
@@ -55,19 +82,31 @@ func NewWatcher(log *slog.Logger, caFiles []string, certFile, privkeyFile string
 // are well-known, but the files themselves don't exist yet. Note that the
 // requirement is that the file directories must exists.
 func FutureWatcher(log *slog.Logger, caFiles []string, certFile, privkeyFile string) (<-chan *Watcher, error) {
+	return FutureWatcherWithOptions(log, caFiles, certFile, privkeyFile, Options{
+		ReconcileInterval: DefaultReconcileInterval,
+	})
+}
+
+// FutureWatcherWithOptions is like FutureWatcher, but lets the caller select
+// the underlying file-watching backend via options. See Options for
+// details.
+func FutureWatcherWithOptions(log *slog.Logger, caFiles []string, certFile, privkeyFile string, options Options) (<-chan *Watcher, error) {
 	r, err := NewFileReloader(caFiles, certFile, privkeyFile)
 	if err != nil {
 		return nil, err
 	}
-	fswatcher, err := newFsWatcher(log, caFiles, certFile, privkeyFile)
+	backend, err := newWatcherBackend(log, caFiles, certFile, privkeyFile, options)
 	if err != nil {
 		return nil, err
 	}
 	w := &Watcher{
-		FileReloader: r,
-		log:          log,
-		fswatcher:    fswatcher,
-		stop:         make(chan struct{}),
+		FileReloader:      r,
+		log:               log,
+		fswatcher:         backend,
+		stop:              make(chan struct{}),
+		subs:              newSubscriptions(),
+		metrics:           options.Metrics,
+		reconcileInterval: options.ReconcileInterval,
 	}
 
 	res := make(chan *Watcher)
@@ -80,8 +119,8 @@ func FutureWatcher(log *slog.Logger, caFiles []string, certFile, privkeyFile str
 		// to load the CA), we only need a successfully handled CA related fs
 		// notify event to become Ready (in other words, we don't need to
 		// receive a fs event for the keypair in that case to become ready).
-		_, keypairErr := w.ReloadKeypair()
-		_, caErr := w.ReloadCA()
+		_, keypairErr := w.reloadKeypair()
+		_, caErr := w.reloadCA()
 		ready := w.Watch()
 		if keypairErr == nil && caErr == nil {
 			log.Debug("TLS configuration ready")
@@ -126,15 +165,55 @@ func (w *Watcher) Watch() <-chan struct{} {
 	for _, path := range w.FileReloader.caFiles {
 		caMap[path] = struct{}{}
 	}
+	// basenames and parent directories of the tracked files, used to match
+	// events delivered against the parent directory rather than the leaf
+	// file itself (e.g. when kubelet atomically swaps the "..data" symlink
+	// of a mounted Secret or ConfigMap, instead of writing the leaf file in
+	// place).
+	keypairBase, keypairDirs := basenamesAndDirs(keypairMap)
+	caBase, caDirs := basenamesAndDirs(caMap)
+
+	keypairPaths := make([]string, 0, len(keypairMap))
+	for path := range keypairMap {
+		keypairPaths = append(keypairPaths, path)
+	}
+	caPaths := make([]string, 0, len(caMap))
+	for path := range caMap {
+		caPaths = append(caPaths, path)
+	}
 
 	// used to coalesce fswatcher events that arrive within the same time window
 	var keypairReload, caReload <-chan time.Time
+	// count of consecutive isTransientAbsence retries since the last
+	// successful reload (or the last Warn), capped by
+	// maxTransientAbsenceRetries so a permanently missing file (bad mount,
+	// deleted Secret, typo'd path) surfaces a Warn instead of retrying
+	// silently forever.
+	var keypairTransientRetries, caTransientRetries int
 
 	go func() {
 		defer w.fswatcher.Close()
+
+		// Periodic reconciliation is a safety net against fsnotify events
+		// that never arrive (a dropped watch, a missed event queue overflow,
+		// a bind mount boundary fsnotify can't see across): on every tick, we
+		// re-hash the tracked files and treat a mismatch against the hash
+		// captured at the last successful load (or, if a reload triggered by
+		// an earlier tick failed, at that tick) exactly like a real fswatcher
+		// event, routing it through the same coalescing channels.
+		var reconcileTick <-chan time.Time
+		var keypairHashes, caHashes *fileHashCache
+		if w.reconcileInterval > 0 {
+			keypairHashes = newFileHashCache(keypairPaths)
+			caHashes = newFileHashCache(caPaths)
+			ticker := time.NewTicker(w.reconcileInterval)
+			defer ticker.Stop()
+			reconcileTick = ticker.C
+		}
+
 		for {
 			select {
-			case event := <-w.fswatcher.Events:
+			case event := <-w.fswatcher.EventsCh():
 				path := event.Name
 				w.log.Debug("Received fswatcher event",
 					logfields.Path, path,
@@ -143,31 +222,69 @@ func (w *Watcher) Watch() <-chan struct{} {
 
 				_, keypairUpdated := keypairMap[path]
 				_, caUpdated := caMap[path]
-
-				if keypairUpdated {
-					if keypairReload == nil {
-						keypairReload = time.After(watcherEventCoalesceWindow)
+				if !keypairUpdated && !caUpdated {
+					// The event may have been raised against the parent
+					// directory of a tracked file rather than the file
+					// itself (atomic symlink swap, e.g. kubelet updating a
+					// mounted Secret/ConfigMap). Treat it as a reload
+					// trigger for a bucket when either the event's basename
+					// matches one of that bucket's tracked files, or it is
+					// the well-known "..data" symlink kubelet swaps,
+					// provided the event was raised in that bucket's
+					// directory.
+					dir, base := filepath.Dir(path), filepath.Base(path)
+					if _, ok := keypairDirs[dir]; ok {
+						_, keypairUpdated = keypairBase[base]
+						keypairUpdated = keypairUpdated || base == atomicWriterDataDir
 					}
-				} else if caUpdated {
-					if caReload == nil {
-						caReload = time.After(watcherEventCoalesceWindow)
+					if _, ok := caDirs[dir]; ok {
+						_, caUpdated = caBase[base]
+						caUpdated = caUpdated || base == atomicWriterDataDir
 					}
-				} else {
+				}
+
+				if !keypairUpdated && !caUpdated {
 					// fswatcher should never send events for unknown files
-					w.log.Warn("Unknown file, ignoring.",
+					w.log.Debug("Unrelated directory event, ignoring.",
 						logfields.Path, path,
 						logfields.Operation, event.Op,
 					)
 					continue
 				}
+				// keypairUpdated and caUpdated are independent: a single
+				// directory event (e.g. the "..data" symlink swap) can
+				// affect both buckets at once when the keypair and CA
+				// files live in the same directory, so both must be
+				// scheduled from the same event.
+				if keypairUpdated && keypairReload == nil {
+					keypairReload = time.After(watcherEventCoalesceWindow)
+				}
+				if caUpdated && caReload == nil {
+					caReload = time.After(watcherEventCoalesceWindow)
+				}
 			case <-keypairReload:
 				keypairReload = nil
 
-				keypair, err := w.ReloadKeypair()
+				keypair, err := w.reloadKeypair()
 				if err != nil {
+					if isTransientAbsence(err) && keypairTransientRetries < maxTransientAbsenceRetries {
+						// The leaf file is mid atomic-swap (e.g. the old
+						// "..data" symlink was removed but the new one
+						// hasn't landed yet); retry once it settles instead
+						// of logging a spurious warning.
+						keypairTransientRetries++
+						w.log.Debug("Keypair file transiently absent, retrying", logfields.Error, err)
+						keypairReload = time.After(watcherEventCoalesceWindow)
+						continue
+					}
+					keypairTransientRetries = 0
 					w.log.Warn("Keypair update failed", logfields.Error, err)
 					continue
 				}
+				keypairTransientRetries = 0
+				if keypairHashes != nil {
+					keypairHashes.changed(keypairPaths)
+				}
 				id := keypairId(keypair)
 				w.log.Info("Keypair updated", logfields.KeyPairSN, id)
 				if w.Ready() {
@@ -176,15 +293,35 @@ func (w *Watcher) Watch() <-chan struct{} {
 			case <-caReload:
 				caReload = nil
 
-				if _, err := w.ReloadCA(); err != nil {
+				if _, err := w.reloadCA(); err != nil {
+					if isTransientAbsence(err) && caTransientRetries < maxTransientAbsenceRetries {
+						caTransientRetries++
+						w.log.Debug("CA file transiently absent, retrying", logfields.Error, err)
+						caReload = time.After(watcherEventCoalesceWindow)
+						continue
+					}
+					caTransientRetries = 0
 					w.log.Warn("Certificate authority update failed", logfields.Error, err)
 					continue
 				}
+				caTransientRetries = 0
+				if caHashes != nil {
+					caHashes.changed(caPaths)
+				}
 				w.log.Info("Certificate authority updated")
 				if w.Ready() {
 					markReady()
 				}
-			case err := <-w.fswatcher.Errors:
+			case <-reconcileTick:
+				if keypairHashes.changed(keypairPaths) && keypairReload == nil {
+					w.log.Debug("Reconcile detected a keypair change missed by fsnotify")
+					keypairReload = time.After(watcherEventCoalesceWindow)
+				}
+				if caHashes.changed(caPaths) && caReload == nil {
+					w.log.Debug("Reconcile detected a CA change missed by fsnotify")
+					caReload = time.After(watcherEventCoalesceWindow)
+				}
+			case err := <-w.fswatcher.ErrorsCh():
 				w.log.Warn("fswatcher error", logfields.Error, err)
 			case <-w.stop:
 				w.log.Info("Stopping fswatcher")
@@ -205,23 +342,97 @@ func (w *Watcher) Stop() {
 	}
 }
 
-// newFsWatcher returns a fswatcher.Watcher watching over the given files.
+// watcherBackend is the event source Watcher.Watch consumes. Both the
+// default fsnotify-based backend and the PollInterval-driven fallback
+// implement it, so Watch's coalescing and keypair/CA routing logic runs
+// unchanged over either one.
+type watcherBackend interface {
+	// EventsCh delivers a synthetic or real fs event for one of the tracked
+	// paths (or, for the fsnotify backend, their parent directories).
+	EventsCh() <-chan fswatcher.Event
+	// ErrorsCh delivers backend errors, logged but otherwise non-fatal.
+	ErrorsCh() <-chan error
+	// Close stops the backend and releases its resources.
+	Close() error
+}
+
+// fsnotifyBackend adapts *fswatcher.Watcher's Events/Errors fields to the
+// watcherBackend method interface.
+type fsnotifyBackend struct {
+	*fswatcher.Watcher
+}
+
+func (b *fsnotifyBackend) EventsCh() <-chan fswatcher.Event { return b.Events }
+func (b *fsnotifyBackend) ErrorsCh() <-chan error           { return b.Errors }
+
+// newWatcherBackend returns the watcherBackend to use given options: the
+// fsnotify-based backend watching the given files and their parent
+// directories, or a polling backend when options.Poll is set or fsnotify is
+// unavailable on this platform/filesystem.
+func newWatcherBackend(logger *slog.Logger, caFiles []string, certFile, privkeyFile string, options Options) (watcherBackend, error) {
+	if options.Poll {
+		return newPollWatcher(logger, caFiles, certFile, privkeyFile, options)
+	}
+
+	fsw, err := newFsWatcher(logger, caFiles, certFile, privkeyFile)
+	if err != nil {
+		logger.Info("fsnotify unavailable, falling back to polling for TLS file changes", logfields.Error, err)
+		return newPollWatcher(logger, caFiles, certFile, privkeyFile, options)
+	}
+	return &fsnotifyBackend{Watcher: fsw}, nil
+}
+
+// newFsWatcher returns a fswatcher.Watcher watching over the given files, as
+// well as their parent directories. Watching the parent directory lets the
+// Watcher pick up atomic symlink swaps (e.g. kubelet updating a mounted
+// Kubernetes Secret or ConfigMap), which replace the inode the leaf file's
+// watch is attached to without ever touching that inode directly.
 // The fswatcher.Watcher supports watching over files which do not exist yet.
 // A create event will be emitted once the file is added.
 func newFsWatcher(logger *slog.Logger, caFiles []string, certFile, privkeyFile string) (*fswatcher.Watcher, error) {
 	trackFiles := []string{}
+	trackDirs := make(map[string]struct{})
 
-	if certFile != "" {
-		trackFiles = append(trackFiles, certFile)
-	}
-	if privkeyFile != "" {
-		trackFiles = append(trackFiles, privkeyFile)
+	addFile := func(path string) {
+		if path == "" {
+			return
+		}
+		trackFiles = append(trackFiles, path)
+		trackDirs[filepath.Dir(path)] = struct{}{}
 	}
+
+	addFile(certFile)
+	addFile(privkeyFile)
 	for _, path := range caFiles {
-		if path != "" {
-			trackFiles = append(trackFiles, path)
-		}
+		addFile(path)
+	}
+
+	for dir := range trackDirs {
+		trackFiles = append(trackFiles, dir)
 	}
 
 	return fswatcher.New(logger, trackFiles)
 }
+
+// atomicWriterDataDir is the name of the symlink that kubelet atomically
+// swaps to point at the latest revision of a mounted Secret or ConfigMap.
+const atomicWriterDataDir = "..data"
+
+// basenamesAndDirs returns the set of basenames and the set of parent
+// directories of the paths in files.
+func basenamesAndDirs(files map[string]struct{}) (basenames, dirs map[string]struct{}) {
+	basenames = make(map[string]struct{}, len(files))
+	dirs = make(map[string]struct{}, len(files))
+	for path := range files {
+		basenames[filepath.Base(path)] = struct{}{}
+		dirs[filepath.Dir(path)] = struct{}{}
+	}
+	return basenames, dirs
+}
+
+// isTransientAbsence reports whether err looks like the tracked file was
+// momentarily missing, as happens mid atomic symlink swap, rather than a
+// genuine configuration problem.
+func isTransientAbsence(err error) bool {
+	return errors.Is(err, os.ErrNotExist)
+}
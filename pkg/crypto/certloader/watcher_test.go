@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package certloader
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cilium/cilium/pkg/time"
+)
+
+// writeKubeletSecret lays out dir the way kubelet renders a mounted
+// Secret/ConfigMap: a timestamped data directory holding the real files, a
+// "..data" symlink pointing at it, and tls.crt/tls.key/ca.crt symlinks
+// pointing through "..data". swap atomically repoints "..data" (and, the
+// first time, creates the leaf symlinks) at a freshly written generation,
+// exactly as kubelet does on every Secret/ConfigMap update.
+func writeKubeletSecret(t *testing.T, dir string, generation int, certPEM, keyPEM, caPEM []byte) {
+	t.Helper()
+
+	dataDir := filepath.Join(dir, "..data_"+big.NewInt(int64(generation)).String())
+	if err := os.Mkdir(dataDir, 0o755); err != nil {
+		t.Fatalf("Mkdir(%s): %v", dataDir, err)
+	}
+	for name, content := range map[string][]byte{
+		"tls.crt": certPEM,
+		"tls.key": keyPEM,
+		"ca.crt":  caPEM,
+	} {
+		if err := os.WriteFile(filepath.Join(dataDir, name), content, 0o600); err != nil {
+			t.Fatalf("WriteFile(%s): %v", name, err)
+		}
+	}
+
+	tmpLink := filepath.Join(dir, ".."+big.NewInt(int64(generation)).String())
+	if err := os.Symlink(dataDir, tmpLink); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+	dataLink := filepath.Join(dir, atomicWriterDataDir)
+	if err := os.Rename(tmpLink, dataLink); err != nil {
+		t.Fatalf("Rename(%s, %s): %v", tmpLink, dataLink, err)
+	}
+
+	for _, name := range []string{"tls.crt", "tls.key", "ca.crt"} {
+		leaf := filepath.Join(dir, name)
+		if _, err := os.Lstat(leaf); err == nil {
+			continue
+		}
+		if err := os.Symlink(filepath.Join(atomicWriterDataDir, name), leaf); err != nil {
+			t.Fatalf("Symlink(%s): %v", name, err)
+		}
+	}
+}
+
+// genSelfSigned returns a PEM-encoded self-signed certificate and its PEM
+// private key, with cn as the certificate's CommonName.
+func genSelfSigned(t *testing.T, cn string) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
+
+// TestWatcher_SameDirectorySymlinkSwap reproduces a single kubelet "..data"
+// symlink swap that updates the keypair and the CA bundle at once, because
+// both live in the same mounted Secret directory. A single fswatcher event
+// on that directory must still result in both buckets being reloaded.
+func TestWatcher_SameDirectorySymlinkSwap(t *testing.T) {
+	dir := t.TempDir()
+
+	cert1, key1 := genSelfSigned(t, "gen1-leaf")
+	ca1, _ := genSelfSigned(t, "gen1-ca")
+	writeKubeletSecret(t, dir, 1, cert1, key1, ca1)
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	caFile := filepath.Join(dir, "ca.crt")
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	w, err := NewWatcher(log, []string{caFile}, certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewWatcher: %v", err)
+	}
+	t.Cleanup(w.Stop)
+
+	keypairUpdates := w.KeypairUpdates()
+	caUpdates := w.CAUpdates()
+
+	cert2, key2 := genSelfSigned(t, "gen2-leaf")
+	ca2, _ := genSelfSigned(t, "gen2-ca")
+	writeKubeletSecret(t, dir, 2, cert2, key2, ca2)
+
+	timeout := time.After(10 * time.Second)
+	var gotKeypair, gotCA bool
+	for !gotKeypair || !gotCA {
+		select {
+		case <-keypairUpdates:
+			gotKeypair = true
+		case <-caUpdates:
+			gotCA = true
+		case <-timeout:
+			t.Fatalf("timed out waiting for reload; keypair=%v ca=%v", gotKeypair, gotCA)
+		}
+	}
+}